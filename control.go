@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// controlAuth guards h behind a bearer token, as required by
+// -control-token. Requests without a matching "Authorization: Bearer
+// <token>" header are rejected.
+func controlAuth(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func clientFor(clients map[string]Client, device string) (Client, error) {
+	client, ok := clients[device]
+	if !ok {
+		return nil, fmt.Errorf("unknown device %q", device)
+	}
+	return client, nil
+}
+
+// controlRequest is implemented by every /control/* request body, giving
+// controlHandler a uniform way to look up the target device.
+type controlRequest interface {
+	device() string
+}
+
+// controlHandler is the common decode -> look up device -> apply shape
+// shared by every /control/* endpoint: decode errors (including a
+// request type's own UnmarshalJSON, e.g. currentRequest's ttl parsing)
+// become 400s, an unknown device a 404, and an apply failure a 502.
+func controlHandler[T controlRequest](clients map[string]Client, apply func(Client, T) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req T
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		client, err := clientFor(clients, req.device())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		if err := apply(client, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+	}
+}
+
+type currentRequest struct {
+	Device string
+	MA     int
+	TTL    time.Duration
+}
+
+func (r currentRequest) device() string { return r.Device }
+
+func (r *currentRequest) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Device string `json:"device"`
+		MA     int    `json:"ma"`
+		TTL    string `json:"ttl"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	r.Device = raw.Device
+	r.MA = raw.MA
+	if raw.TTL != "" {
+		ttl, err := time.ParseDuration(raw.TTL)
+		if err != nil {
+			return fmt.Errorf("invalid ttl: %w", err)
+		}
+		r.TTL = ttl
+	}
+	return nil
+}
+
+func controlCurrentHandler(clients map[string]Client) http.HandlerFunc {
+	return controlHandler(clients, func(c Client, req currentRequest) error {
+		return c.SetCurrent(req.MA, req.TTL)
+	})
+}
+
+type energyRequest struct {
+	Device string `json:"device"`
+	DeciWh int    `json:"deci_wh"`
+}
+
+func (r energyRequest) device() string { return r.Device }
+
+func controlEnergyHandler(clients map[string]Client) http.HandlerFunc {
+	return controlHandler(clients, func(c Client, req energyRequest) error {
+		return c.SetEnergyLimit(req.DeciWh)
+	})
+}
+
+type enableRequest struct {
+	Device string `json:"device"`
+	Enable bool   `json:"enable"`
+}
+
+func (r enableRequest) device() string { return r.Device }
+
+func controlEnableHandler(clients map[string]Client) http.HandlerFunc {
+	return controlHandler(clients, func(c Client, req enableRequest) error {
+		return c.Enable(req.Enable)
+	})
+}
+
+type unlockRequest struct {
+	Device string `json:"device"`
+}
+
+func (r unlockRequest) device() string { return r.Device }
+
+func controlUnlockHandler(clients map[string]Client) http.HandlerFunc {
+	return controlHandler(clients, func(c Client, req unlockRequest) error {
+		return c.Unlock()
+	})
+}
+
+type displayRequest struct {
+	Device string `json:"device"`
+	Text   string `json:"text"`
+}
+
+func (r displayRequest) device() string { return r.Device }
+
+func controlDisplayHandler(clients map[string]Client) http.HandlerFunc {
+	return controlHandler(clients, func(c Client, req displayRequest) error {
+		return c.Display(req.Text)
+	})
+}
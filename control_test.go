@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClient is a minimal Client stub for exercising /control/* handlers
+// without a real wallbox.
+type fakeClient struct {
+	Client // embed the zero value; only the methods under test are overridden
+
+	setCurrentErr error
+	gotMA         int
+	gotTTL        time.Duration
+}
+
+func (f *fakeClient) SetCurrent(mA int, ttl time.Duration) error {
+	f.gotMA, f.gotTTL = mA, ttl
+	return f.setCurrentErr
+}
+
+func TestControlCurrentHandler(t *testing.T) {
+	fc := &fakeClient{}
+	clients := map[string]Client{"wallbox1": fc}
+	h := controlCurrentHandler(clients)
+
+	t.Run("applies current and ttl", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/control/current", strings.NewReader(`{"device":"wallbox1","ma":16000,"ttl":"1h"}`))
+		w := httptest.NewRecorder()
+		h(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if fc.gotMA != 16000 || fc.gotTTL != time.Hour {
+			t.Errorf("SetCurrent(%d, %v), want (16000, 1h)", fc.gotMA, fc.gotTTL)
+		}
+	})
+
+	t.Run("invalid ttl is a 400", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/control/current", strings.NewReader(`{"device":"wallbox1","ma":16000,"ttl":"nope"}`))
+		w := httptest.NewRecorder()
+		h(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("unknown device is a 404", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/control/current", strings.NewReader(`{"device":"wallbox2","ma":16000}`))
+		w := httptest.NewRecorder()
+		h(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("client error is a 502", func(t *testing.T) {
+		fc := &fakeClient{setCurrentErr: errors.New("boom")}
+		h := controlCurrentHandler(map[string]Client{"wallbox1": fc})
+
+		req := httptest.NewRequest(http.MethodPost, "/control/current", strings.NewReader(`{"device":"wallbox1","ma":16000}`))
+		w := httptest.NewRecorder()
+		h(w, req)
+
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusBadGateway)
+		}
+	})
+}
+
+func TestControlAuth(t *testing.T) {
+	ok := controlAuth("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"matching token", "Bearer secret", http.StatusOK},
+		{"wrong token", "Bearer nope", http.StatusUnauthorized},
+		{"missing header", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/control/current", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			ok.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
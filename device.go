@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Device describes a single wallbox to scrape, either given on the
+// command line via -target/a positional argument, or loaded from a
+// -config YAML file.
+type Device struct {
+	Name           string `yaml:"name"`
+	Address        string `yaml:"address"`
+	Protocol       string `yaml:"protocol"`
+	ScrapeInterval string `yaml:"scrape_interval"`
+}
+
+// protocol returns d.Protocol, falling back to "udp".
+func (d Device) protocol() string {
+	if d.Protocol == "" {
+		return "udp"
+	}
+	return d.Protocol
+}
+
+// interval returns d.ScrapeInterval parsed as a duration, falling back
+// to 10s if unset or invalid.
+func (d Device) interval() time.Duration {
+	if d.ScrapeInterval == "" {
+		return 10 * time.Second
+	}
+	iv, err := time.ParseDuration(d.ScrapeInterval)
+	if err != nil {
+		return 10 * time.Second
+	}
+	return iv
+}
+
+func newClient(d Device) (Client, error) {
+	switch d.protocol() {
+	case "udp":
+		return newUDP(d.Address)
+	case "modbus":
+		return newModbus(d.Address)
+	default:
+		return nil, fmt.Errorf("device %q: unknown protocol %q", d.Name, d.Protocol)
+	}
+}
+
+// targetFlag collects repeated -target flags into a slice of "host" or
+// "name=host" strings.
+type targetFlag []string
+
+func (t *targetFlag) String() string { return strings.Join(*t, ",") }
+
+func (t *targetFlag) Set(v string) error {
+	*t = append(*t, v)
+	return nil
+}
+
+// parseTarget turns a -target value or positional argument into a
+// Device. "name=host" sets a Prometheus label distinct from the
+// connect address; a bare "host" is used for both.
+func parseTarget(s string) Device {
+	if name, addr, ok := strings.Cut(s, "="); ok {
+		return Device{Name: name, Address: addr}
+	}
+	return Device{Name: s, Address: s}
+}
+
+type fileConfig struct {
+	Devices []Device `yaml:"devices"`
+}
+
+func loadConfig(path string) ([]Device, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg fileConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg.Devices, nil
+}
+
+// devicesFrom merges devices from -config, repeated -target flags and a
+// single legacy positional argument, applying defaultProtocol wherever
+// a device doesn't specify its own.
+func devicesFrom(configPath string, targets targetFlag, args []string, defaultProtocol string) ([]Device, error) {
+	var devices []Device
+
+	if configPath != "" {
+		d, err := loadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, d...)
+	}
+
+	for _, t := range targets {
+		devices = append(devices, parseTarget(t))
+	}
+
+	switch len(args) {
+	case 0:
+	case 1:
+		devices = append(devices, parseTarget(args[0]))
+	default:
+		return nil, fmt.Errorf("unexpected extra arguments: %v", args[1:])
+	}
+
+	for i, d := range devices {
+		if d.Protocol == "" {
+			devices[i].Protocol = defaultProtocol
+		}
+	}
+
+	seen := make(map[string]bool, len(devices))
+	for _, d := range devices {
+		if seen[d.Name] {
+			return nil, fmt.Errorf("duplicate device name %q", d.Name)
+		}
+		seen[d.Name] = true
+	}
+
+	return devices, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Device
+	}{
+		{"10.0.0.1", Device{Name: "10.0.0.1", Address: "10.0.0.1"}},
+		{"wallbox1=10.0.0.1", Device{Name: "wallbox1", Address: "10.0.0.1"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseTarget(tt.in); got != tt.want {
+			t.Errorf("parseTarget(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDevicesFromMergesTargetsAndArgs(t *testing.T) {
+	targets := targetFlag{"wallbox1=10.0.0.1", "10.0.0.2"}
+
+	devices, err := devicesFrom("", targets, []string{"10.0.0.3"}, "udp")
+	if err != nil {
+		t.Fatalf("devicesFrom: %v", err)
+	}
+
+	want := []Device{
+		{Name: "wallbox1", Address: "10.0.0.1", Protocol: "udp"},
+		{Name: "10.0.0.2", Address: "10.0.0.2", Protocol: "udp"},
+		{Name: "10.0.0.3", Address: "10.0.0.3", Protocol: "udp"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("devicesFrom() = %+v, want %+v", devices, want)
+	}
+}
+
+func TestDevicesFromRejectsExtraArgs(t *testing.T) {
+	_, err := devicesFrom("", nil, []string{"a", "b"}, "udp")
+	if err == nil {
+		t.Fatal("devicesFrom() with two positional args: want error, got nil")
+	}
+}
+
+func TestDevicesFromRejectsDuplicateNames(t *testing.T) {
+	targets := targetFlag{"wallbox1=10.0.0.1", "wallbox1=10.0.0.2"}
+	_, err := devicesFrom("", targets, nil, "udp")
+	if err == nil {
+		t.Fatal("devicesFrom() with duplicate device names: want error, got nil")
+	}
+}
+
+func TestDevicesFromKeepsConfigProtocol(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/config.yaml"
+	yaml := "devices:\n  - name: wallbox1\n    address: 10.0.0.1\n    protocol: modbus\n  - name: wallbox2\n    address: 10.0.0.2\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	devices, err := devicesFrom(path, nil, nil, "udp")
+	if err != nil {
+		t.Fatalf("devicesFrom: %v", err)
+	}
+
+	want := []Device{
+		{Name: "wallbox1", Address: "10.0.0.1", Protocol: "modbus"},
+		{Name: "wallbox2", Address: "10.0.0.2", Protocol: "udp"},
+	}
+	if !reflect.DeepEqual(devices, want) {
+		t.Errorf("devicesFrom() = %+v, want %+v", devices, want)
+	}
+}
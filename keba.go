@@ -158,6 +158,29 @@ type Client interface {
 
 	// History of charging sessions
 	History() ([]Log, error)
+
+	// SetCurrent sets the maximum charging current in mA. If ttl is
+	// positive, the limit reverts to its previous value after ttl
+	// elapses; a non-positive ttl sets it permanently.
+	SetCurrent(mA int, ttl time.Duration) error
+
+	// SetEnergyLimit sets the energy limit for the current charging
+	// session, in 0.1Wh steps. 0 clears the limit.
+	SetEnergyLimit(deciWh int) error
+
+	// Enable starts or stops charging.
+	Enable(on bool) error
+
+	// Unlock releases the cable lock.
+	Unlock() error
+
+	// Display shows text on the wallbox's display.
+	Display(text string) error
+
+	// Close releases any resources held open between calls (e.g. a
+	// persistent Modbus TCP connection). Callers that only make a
+	// single scrape, such as probeHandler, must call it when done.
+	Close() error
 }
 
 type udp struct {
@@ -188,15 +211,25 @@ func newUDP(host string) (*udp, error) {
 	return &udp, nil
 }
 
-func (u *udp) msg(cmd string, ptr interface{}) error {
-	u.mu.Lock()
-	defer u.mu.Unlock()
-
+func (u *udp) dial() (*net.UDPConn, error) {
 	raddr := &u.addr
 	laddr := &net.UDPAddr{Port: raddr.Port}
 	laddr.IP = nil
 
 	conn, err := net.DialUDP("udp", laddr, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return conn, nil
+}
+
+func (u *udp) msg(cmd string, ptr interface{}) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	conn, err := u.dial()
 	if err != nil {
 		return err
 	}
@@ -206,11 +239,37 @@ func (u *udp) msg(cmd string, ptr interface{}) error {
 		return err
 	}
 
-	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-
 	return json.NewDecoder(conn).Decode(&ptr)
 }
 
+// ctrl sends a control command that acks with a plain-text "TCH-OK"/
+// "TCH-ERR" reply rather than a JSON report.
+func (u *udp) ctrl(cmd string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	conn, err := u.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	if resp := strings.TrimSpace(string(buf[:n])); strings.Contains(resp, "ERR") {
+		return fmt.Errorf("keba: command %q failed: %s", cmd, resp)
+	}
+	return nil
+}
+
 func (u *udp) System() (*System, error) {
 	var s System
 	err := u.msg("report 1", &s)
@@ -254,6 +313,41 @@ func (u *udp) History() ([]Log, error) {
 	return hist, nil
 }
 
+func (u *udp) SetCurrent(mA int, ttl time.Duration) error {
+	if ttl > 0 {
+		return u.ctrl(fmt.Sprintf("currtime %d %d", mA, int(ttl.Seconds())))
+	}
+	return u.ctrl(fmt.Sprintf("curr %d", mA))
+}
+
+func (u *udp) SetEnergyLimit(deciWh int) error {
+	return u.ctrl(fmt.Sprintf("setenergy %d", deciWh))
+}
+
+func (u *udp) Enable(on bool) error {
+	return u.ctrl(fmt.Sprintf("ena %d", btoi(on)))
+}
+
+func (u *udp) Unlock() error {
+	return u.ctrl("unlock")
+}
+
+func (u *udp) Display(text string) error {
+	return u.ctrl(fmt.Sprintf("display 0 0 0 0 %s", text))
+}
+
+// Close is a no-op: udp dials a fresh UDP socket per call and closes it
+// immediately after (see msg/ctrl), so there is nothing held open
+// between calls.
+func (u *udp) Close() error { return nil }
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 type DIPs uint16
 
 func (d DIPs) Has(i uint16) bool {
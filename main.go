@@ -1,221 +1,94 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"sync"
-	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const Namespace = "keba"
 
-var (
-	voltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Name:      "voltage",
-		Help:      "Voltage of the 3 phases in volts",
-	}, []string{"phase"})
-
-	current = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Name:      "current",
-		Help:      "Current of the 3 phases in ampere",
-	}, []string{"phase"})
-	currentLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Name:      "current_limit",
-		Help:      "Maximum amperes permitted",
-	}, []string{"kind"})
-
-	power = promauto.NewGauge(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Name:      "power",
-		Help:      "Power draw in watts",
-	})
-
-	whTotal     F
-	whSession   F
-	energyTotal = promauto.NewCounterFunc(prometheus.CounterOpts{
-		Namespace: Namespace,
-		Name:      "energy_total_wh",
-		Help:      "Total energy supplied by the wallbox in Wh",
-	}, whTotal.Get)
-	energySession = promauto.NewCounterFunc(prometheus.CounterOpts{
-		Namespace: Namespace,
-		Name:      "energy_session_wh",
-		Help:      "Energy supplied by the wallbox during this charging session in Wh",
-	}, whSession.Get)
-	energySessionLimit = promauto.NewGauge(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Name:      "energy_session_limit",
-		Help:      "Maximum energy to be supplied in this charging session",
-	})
-
-	status = promauto.NewGauge(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Name:      "status",
-		Help:      "State of the charging station (Starting, NotReady, Ready, Charging, Error, AuthRejected)",
-	})
-	plugStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: Namespace,
-		Name:      "plug_status",
-		Help:      "Status of the plug (cable)",
-	}, []string{"kind"})
-)
-
-var (
-	udpTotal = promauto.NewCounter(prometheus.CounterOpts{
-		Namespace: Namespace,
-		Subsystem: "scrape",
-		Name:      "total",
-	})
-
-	udpErrs = promauto.NewCounter(prometheus.CounterOpts{
-		Namespace: Namespace,
-		Subsystem: "scrape",
-		Name:      "errors",
-	})
-)
-
 func main() {
 	addr := flag.String("http", ":2112", "http address to bind to")
+	protocol := flag.String("protocol", "udp", "default wallbox protocol: udp or modbus")
+	config := flag.String("config", "", "path to a YAML file listing devices to scrape")
+	historyDB := flag.String("history-db", "", "path to a SQLite database for persisting session history indefinitely")
+	controlToken := flag.String("control-token", "", "bearer token required by /control/* endpoints; control endpoints are disabled if unset")
+	var mqttCfg mqttConfig
+	flag.StringVar(&mqttCfg.Broker, "mqtt-broker", "", "MQTT broker URL (e.g. tcp://localhost:1883); MQTT publishing is disabled if unset")
+	flag.StringVar(&mqttCfg.TopicPrefix, "mqtt-topic-prefix", "keba", "topic prefix each device's fields are published under")
+	flag.StringVar(&mqttCfg.Username, "mqtt-username", "", "MQTT username")
+	flag.StringVar(&mqttCfg.Password, "mqtt-password", "", "MQTT password")
+	flag.BoolVar(&mqttCfg.TLS, "mqtt-tls", false, "use TLS to connect to the MQTT broker")
+	var targets targetFlag
+	flag.Var(&targets, "target", "wallbox to scrape, repeatable (host or name=host); alternative to a positional argument or -config")
 	flag.Parse()
 
-	if flag.NArg() != 1 {
-		fmt.Println("Error: Requires exactly 1 argument")
-		flag.Usage()
-		os.Exit(1)
+	if *historyDB != "" {
+		s, err := newSQLiteStore(*historyDB)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		store = s
 	}
 
-	udp, err := newUDP(flag.Arg(0))
+	devices, err := devicesFrom(*config, targets, flag.Args(), *protocol)
 	if err != nil {
 		log.Fatalln(err)
 	}
-
-	go metrics(udp)
-	go history(udp)
-
-	log.Printf("http: listening on %s", *addr)
-	if err := http.ListenAndServe(*addr, nil); err != nil {
-		log.Fatalln(err)
+	if len(devices) == 0 {
+		fmt.Println("Error: no devices given, pass a host, -target or -config")
+		flag.Usage()
+		os.Exit(1)
 	}
-}
-
-func metrics(udp Client) {
-	http.Handle("/metrics", promhttp.Handler())
-
-	ticker := time.NewTicker(10 * time.Second)
-	for ; true; <-ticker.C {
-		udpTotal.Inc()
-		cfg, err := udp.Config()
-		if err == nil {
-			// current limits
-			gauge(currentLimit, "hw").Set(float64(cfg.MaxCurrent) / 1000)
-			gauge(currentLimit, "user").Set(float64(cfg.CurrentLimit) / 1000)
-
-			// device status
-			status.Set(float64(cfg.State))
-
-			// plug status
-			gauge(plugStatus, "station").Set(btof(cfg.Plug&PlugStation != 0))
-			gauge(plugStatus, "locked").Set(btof(cfg.Plug&PlugLocked != 0))
-			gauge(plugStatus, "ev").Set(btof(cfg.Plug&PlugEV != 0))
-		} else {
-			udpErrs.Inc()
-			log.Println(err)
-		}
 
-		udpTotal.Inc()
-		sess, err := udp.Session()
+	clients := make(map[string]Client, len(devices))
+	for _, d := range devices {
+		raw, err := newClient(d)
 		if err != nil {
-			udpErrs.Inc()
-			log.Println(err)
+			log.Printf("%s: %v, skipping this device", d.Name, err)
 			continue
 		}
 
-		// voltages
-		gauge(voltage, "1").Set(float64(sess.Voltage1))
-		gauge(voltage, "2").Set(float64(sess.Voltage2))
-		gauge(voltage, "3").Set(float64(sess.Voltage3))
-
-		// currents
-		gauge(current, "1").Set(float64(sess.Current1) / 1000)
-		gauge(current, "2").Set(float64(sess.Current2) / 1000)
-		gauge(current, "3").Set(float64(sess.Current3) / 1000)
-
-		// power
-		power.Set(float64(sess.Power) / 1000)
-
-		// energy
-		whTotal.Set(float64(sess.Total) / 10)
-		whSession.Set(float64(sess.Energy) / 10)
-	}
-}
-
-func history(udp Client) {
-	var hist []Log
-	var mu sync.RWMutex
-
-	http.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
-		mu.RLock()
-		defer mu.RUnlock()
-
-		data, err := json.Marshal(hist)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		m := metricsFor(d.Name)
+		client := newRetryClient(raw, m.scrapeRetries.Inc)
+		clients[d.Name] = client
+
+		if mqttCfg.enabled() {
+			sys, err := client.System()
+			if err != nil {
+				log.Printf("mqtt: %s: %v, skipping MQTT publisher for this device", d.Name, err)
+			} else if pub, err := newMQTTPublisher(mqttCfg, d.Name, client, sys); err != nil {
+				log.Printf("mqtt: %s: %v, skipping MQTT publisher for this device", d.Name, err)
+			} else {
+				setMQTTPublisher(d.Name, pub)
+			}
 		}
 
-		w.Write(data)
-	})
-
-	ticker := time.NewTicker(10 * time.Second)
-	for ; true; <-ticker.C {
-		h, err := udp.History()
-		if err != nil {
-			log.Println(err)
-			continue
-		}
-		mu.Lock()
-		hist = h
-		mu.Unlock()
+		go metrics(d.Name, client, d.interval())
+		go history(d.Name, client, d.interval())
 	}
-}
 
-func gauge(vec *prometheus.GaugeVec, lvs ...string) prometheus.Gauge {
-	g, err := vec.GetMetricWithLabelValues(lvs...)
-	if err != nil {
-		panic(err)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/history", historyHandler)
+	http.HandleFunc("/probe", probeHandler(devices))
+
+	if *controlToken != "" {
+		http.Handle("/control/current", controlAuth(*controlToken, controlCurrentHandler(clients)))
+		http.Handle("/control/energy", controlAuth(*controlToken, controlEnergyHandler(clients)))
+		http.Handle("/control/enable", controlAuth(*controlToken, controlEnableHandler(clients)))
+		http.Handle("/control/unlock", controlAuth(*controlToken, controlUnlockHandler(clients)))
+		http.Handle("/control/display", controlAuth(*controlToken, controlDisplayHandler(clients)))
 	}
-	return g
-}
 
-// F is a concurrency safe float
-type F struct {
-	val float64
-	mu  sync.RWMutex
-}
-
-func (f *F) Set(v float64) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	f.val = v
-}
-
-func (f *F) Get() float64 {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-
-	return f.val
+	log.Printf("http: listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, nil); err != nil {
+		log.Fatalln(err)
+	}
 }
 
 func btof(b bool) float64 {
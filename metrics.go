@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// deviceMetrics is the full set of Prometheus collectors for one
+// wallbox. It's built against an arbitrary Registerer so the same
+// construction logic backs both the continuously-scraped default
+// registry (one set per configured device, kept in metricsRegistry)
+// and the throwaway registry used per /probe request.
+type deviceMetrics struct {
+	voltage      *prometheus.GaugeVec
+	current      *prometheus.GaugeVec
+	currentLimit *prometheus.GaugeVec
+
+	power              prometheus.Gauge
+	energySessionLimit prometheus.Gauge
+
+	status     prometheus.Gauge
+	plugStatus *prometheus.GaugeVec
+
+	whTotal   F
+	whSession F
+
+	scrapeTotal    prometheus.Counter
+	scrapeErrors   prometheus.Counter
+	scrapeRetries  prometheus.Counter
+	scrapeDuration prometheus.Histogram
+	lastSuccess    prometheus.Gauge
+	up             prometheus.Gauge
+
+	sessionsTotal     *prometheus.CounterVec
+	lastSessionEnergy prometheus.Gauge
+}
+
+func newDeviceMetrics(reg prometheus.Registerer, device string) *deviceMetrics {
+	labels := prometheus.Labels{"device": device}
+	f := promauto.With(reg)
+	m := &deviceMetrics{}
+
+	m.voltage = f.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "voltage",
+		Help:        "Voltage of the 3 phases in volts",
+		ConstLabels: labels,
+	}, []string{"phase"})
+
+	m.current = f.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "current",
+		Help:        "Current of the 3 phases in ampere",
+		ConstLabels: labels,
+	}, []string{"phase"})
+	m.currentLimit = f.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "current_limit",
+		Help:        "Maximum amperes permitted",
+		ConstLabels: labels,
+	}, []string{"kind"})
+
+	m.power = f.NewGauge(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "power",
+		Help:        "Power draw in watts",
+		ConstLabels: labels,
+	})
+
+	f.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Name:        "energy_total_wh",
+		Help:        "Total energy supplied by the wallbox in Wh",
+		ConstLabels: labels,
+	}, m.whTotal.Get)
+	f.NewCounterFunc(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Name:        "energy_session_wh",
+		Help:        "Energy supplied by the wallbox during this charging session in Wh",
+		ConstLabels: labels,
+	}, m.whSession.Get)
+	m.energySessionLimit = f.NewGauge(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "energy_session_limit",
+		Help:        "Maximum energy to be supplied in this charging session",
+		ConstLabels: labels,
+	})
+
+	m.status = f.NewGauge(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "status",
+		Help:        "State of the charging station (Starting, NotReady, Ready, Charging, Error, AuthRejected)",
+		ConstLabels: labels,
+	})
+	m.plugStatus = f.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "plug_status",
+		Help:        "Status of the plug (cable)",
+		ConstLabels: labels,
+	}, []string{"kind"})
+
+	m.scrapeTotal = f.NewCounter(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Subsystem:   "scrape",
+		Name:        "total",
+		ConstLabels: labels,
+	})
+	m.scrapeErrors = f.NewCounter(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Subsystem:   "scrape",
+		Name:        "errors",
+		ConstLabels: labels,
+	})
+	m.scrapeRetries = f.NewCounter(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Subsystem:   "scrape",
+		Name:        "retries_total",
+		Help:        "Number of retried report requests against the wallbox",
+		ConstLabels: labels,
+	})
+	m.scrapeDuration = f.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   Namespace,
+		Subsystem:   "scrape",
+		Name:        "duration_seconds",
+		Help:        "Time it took to complete a scrape of the wallbox",
+		ConstLabels: labels,
+	})
+
+	m.lastSuccess = f.NewGauge(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "scrape_last_success_timestamp_seconds",
+		Help:        "Unix timestamp of the last scrape that completed without error",
+		ConstLabels: labels,
+	})
+	m.up = f.NewGauge(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "up",
+		Help:        "Whether the last scrape of the wallbox succeeded",
+		ConstLabels: labels,
+	})
+
+	m.sessionsTotal = f.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   Namespace,
+		Name:        "sessions_total",
+		Help:        "Number of charging sessions recorded in the history store",
+		ConstLabels: labels,
+	}, []string{"rfid_class"})
+	m.lastSessionEnergy = f.NewGauge(prometheus.GaugeOpts{
+		Namespace:   Namespace,
+		Name:        "last_session_energy_wh",
+		Help:        "Energy supplied during the most recently recorded charging session in Wh",
+		ConstLabels: labels,
+	})
+
+	return m
+}
+
+var (
+	metricsMu       sync.Mutex
+	metricsByDevice = map[string]*deviceMetrics{}
+)
+
+// metricsFor returns the deviceMetrics registered against the default
+// (continuously-scraped) registry for device, creating it on first use.
+func metricsFor(device string) *deviceMetrics {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	m, ok := metricsByDevice[device]
+	if !ok {
+		m = newDeviceMetrics(prometheus.DefaultRegisterer, device)
+		metricsByDevice[device] = m
+	}
+	return m
+}
+
+func metrics(name string, client Client, interval time.Duration) {
+	m := metricsFor(name)
+
+	ticker := time.NewTicker(interval)
+	for ; true; <-ticker.C {
+		collect(m, client, mqttPublisherFor(name))
+	}
+}
+
+// collect scrapes client once for both the Prometheus collectors in m
+// and, if pub is non-nil, the MQTT publisher - the same Config/Session
+// snapshot feeds both, so enabling MQTT never doubles wallbox traffic.
+func collect(m *deviceMetrics, client Client, pub *mqttPublisher) {
+	start := time.Now()
+	ok := true
+
+	m.scrapeTotal.Inc()
+	cfg, err := client.Config()
+	if err == nil {
+		// current limits
+		gauge(m.currentLimit, "hw").Set(float64(cfg.MaxCurrent) / 1000)
+		gauge(m.currentLimit, "user").Set(float64(cfg.CurrentLimit) / 1000)
+
+		// device status
+		m.status.Set(float64(cfg.State))
+
+		// plug status
+		gauge(m.plugStatus, "station").Set(btof(cfg.Plug&PlugStation != 0))
+		gauge(m.plugStatus, "locked").Set(btof(cfg.Plug&PlugLocked != 0))
+		gauge(m.plugStatus, "ev").Set(btof(cfg.Plug&PlugEV != 0))
+	} else {
+		m.scrapeErrors.Inc()
+		log.Println(err)
+		ok = false
+	}
+
+	m.scrapeTotal.Inc()
+	sess, err := client.Session()
+	if err == nil {
+		// voltages
+		gauge(m.voltage, "1").Set(float64(sess.Voltage1))
+		gauge(m.voltage, "2").Set(float64(sess.Voltage2))
+		gauge(m.voltage, "3").Set(float64(sess.Voltage3))
+
+		// currents
+		gauge(m.current, "1").Set(float64(sess.Current1) / 1000)
+		gauge(m.current, "2").Set(float64(sess.Current2) / 1000)
+		gauge(m.current, "3").Set(float64(sess.Current3) / 1000)
+
+		// power
+		m.power.Set(float64(sess.Power) / 1000)
+
+		// energy
+		m.whTotal.Set(float64(sess.Total) / 10)
+		m.whSession.Set(float64(sess.Energy) / 10)
+	} else {
+		m.scrapeErrors.Inc()
+		log.Println(err)
+		ok = false
+	}
+
+	if ok && pub != nil {
+		pub.publish(cfg, sess)
+	}
+
+	m.scrapeDuration.Observe(time.Since(start).Seconds())
+	m.up.Set(btof(ok))
+	if ok {
+		m.lastSuccess.Set(float64(time.Now().Unix()))
+	}
+}
+
+var (
+	historyMu       sync.RWMutex
+	historyByDevice = map[string][]Log{}
+)
+
+func history(name string, client Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for ; true; <-ticker.C {
+		h, err := client.History()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		historyMu.Lock()
+		historyByDevice[name] = h
+		historyMu.Unlock()
+
+		if store == nil {
+			continue
+		}
+		ingest(name, h)
+	}
+}
+
+// ingest persists h to the configured store and updates the derived
+// sessions_total/last_session_energy_wh metrics for newly seen sessions.
+func ingest(device string, h []Log) {
+	m := metricsFor(device)
+	for _, l := range h {
+		inserted, err := store.Ingest(device, l)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if inserted {
+			counter(m.sessionsTotal, l.RFIDClass).Inc()
+			m.lastSessionEnergy.Set(float64(l.Energy) / 10)
+		}
+	}
+}
+
+// historyHandler serves /history. Without a persistent store (see
+// store.go), it returns the wallbox's own in-RAM ring buffer, optionally
+// narrowed to one ?device=. With a store configured, it additionally
+// accepts ?from=&to= (RFC3339) and ?rfid= range filters and a
+// ?format=json|csv (default json) rendering of the matched sessions.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if store == nil {
+		historyMu.RLock()
+		defer historyMu.RUnlock()
+
+		var (
+			data []byte
+			err  error
+		)
+		if name := r.URL.Query().Get("device"); name != "" {
+			data, err = json.Marshal(historyByDevice[name])
+		} else {
+			data, err = json.Marshal(historyByDevice)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(data)
+		return
+	}
+
+	q := Query{
+		Device: r.URL.Query().Get("device"),
+		RFID:   r.URL.Query().Get("rfid"),
+	}
+	if from := r.URL.Query().Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid from: %v", err), http.StatusBadRequest)
+			return
+		}
+		q.From = t
+	}
+	if to := r.URL.Query().Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid to: %v", err), http.StatusBadRequest)
+			return
+		}
+		q.To = t
+	}
+
+	logs, err := store.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		writeHistoryCSV(w, logs)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(logs)
+	}
+}
+
+func writeHistoryCSV(w http.ResponseWriter, logs []StoredLog) {
+	w.Header().Set("Content-Type", "text/csv")
+
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	cw.Write([]string{"device", "session", "max_current", "start_total", "energy", "start", "end", "end_reason", "rfid_tag", "rfid_class"})
+	for _, l := range logs {
+		cw.Write([]string{
+			l.Device,
+			strconv.Itoa(l.Session),
+			strconv.Itoa(l.MaxCurrent),
+			strconv.Itoa(l.StartTotal),
+			strconv.Itoa(l.Energy),
+			strconv.Itoa(l.Start),
+			strconv.Itoa(l.End),
+			strconv.Itoa(l.EndReason),
+			l.RFIDTag,
+			l.RFIDClass,
+		})
+	}
+}
+
+// probeHandler implements the Prometheus multi-target exporter pattern:
+// /probe?target=<device> runs one synchronous scrape of that device
+// against a throwaway registry, independent of the continuously
+// scraped devices served at /metrics. This lets Prometheus address
+// individual wallboxes via relabel_configs, as with blackbox_exporter.
+func probeHandler(devices []Device) http.HandlerFunc {
+	byName := make(map[string]Device, len(devices))
+	for _, d := range devices {
+		byName[d.Name] = d
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("target")
+		d, ok := byName[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+			return
+		}
+
+		raw, err := newClient(d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer raw.Close()
+
+		reg := prometheus.NewRegistry()
+		m := newDeviceMetrics(reg, d.Name)
+		client := newRetryClient(raw, m.scrapeRetries.Inc)
+		collect(m, client, nil)
+
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}
+}
+
+func gauge(vec *prometheus.GaugeVec, lvs ...string) prometheus.Gauge {
+	g, err := vec.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+func counter(vec *prometheus.CounterVec, lvs ...string) prometheus.Counter {
+	c, err := vec.GetMetricWithLabelValues(lvs...)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// F is a concurrency safe float
+type F struct {
+	val float64
+	mu  sync.RWMutex
+}
+
+func (f *F) Set(v float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.val = v
+}
+
+func (f *F) Get() float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.val
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// ModbusPort is the default Modbus TCP port KEBA P30 wallboxes listen on
+// once DIP_Modbus is set.
+const ModbusPort = 502
+
+// Register addresses of the KeContact P30 Modbus TCP map (see the
+// "KeContact P30 Modbus TCP" programming manual). All registers are read
+// as input registers; multi-register values are big-endian.
+const (
+	regState        = 1000 // charging state, see State* consts
+	regError1       = 1006
+	regError2       = 1007
+	regPlug         = 1008 // plug status, see Plug* consts
+	regEnableSys    = 1010
+	regMaxCurrent   = 1012 // Curr HW, mA
+	regCurrentLimit = 1014 // Curr user, mA
+
+	regVoltage1 = 1040
+	regVoltage2 = 1042
+	regVoltage3 = 1044
+	regCurrent1 = 1046 // mA
+	regCurrent2 = 1048
+	regCurrent3 = 1050
+	regPower    = 1052 // mW, 32bit
+
+	regEnergyTotal   = 1036 // 0.1Wh, 32bit
+	regEnergySession = 1038 // 0.1Wh, 32bit
+	regEnergyLimit   = 1020 // 0.1Wh, 32bit, Setenergy
+
+	regRFIDTag   = 1500 // 5 registers, packed ASCII
+	regRFIDClass = 1505 // 5 registers, packed ASCII
+)
+
+// Holding registers used to control the wallbox.
+const (
+	holdEnable       = 5004 // 1=enabled, 0=disabled
+	holdCurrent      = 5006 // mA
+	holdCurrentTimer = 5008 // seconds until holdCurrent reverts, 0=permanent
+	holdUnlock       = 5010 // write 1 to release the cable lock
+	holdEnergyLimit  = 5012 // 0.1Wh, 32bit, 0=no limit
+)
+
+type modbusClient struct {
+	handler *modbus.TCPClientHandler
+	client  modbus.Client
+	mu      sync.Mutex
+}
+
+var _ Client = &modbusClient{}
+
+func newModbus(host string) (*modbusClient, error) {
+	handler := modbus.NewTCPClientHandler(fmt.Sprintf("%s:%d", host, ModbusPort))
+	handler.Timeout = 2 * time.Second
+	handler.SlaveId = 255
+
+	if err := handler.Connect(); err != nil {
+		return nil, err
+	}
+
+	return &modbusClient{
+		handler: handler,
+		client:  modbus.NewClient(handler),
+	}, nil
+}
+
+func (m *modbusClient) System() (*System, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dips, err := m.u16(regEnableSys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &System{
+		Backend: 1,
+		DIPs:    DIPs(dips),
+	}, nil
+}
+
+func (m *modbusClient) Config() (*Config, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state, err := m.u16(regState)
+	if err != nil {
+		return nil, err
+	}
+	plug, err := m.u16(regPlug)
+	if err != nil {
+		return nil, err
+	}
+	maxCurrent, err := m.u16(regMaxCurrent)
+	if err != nil {
+		return nil, err
+	}
+	currentLimit, err := m.u16(regCurrentLimit)
+	if err != nil {
+		return nil, err
+	}
+	energyLimit, err := m.u32(regEnergyLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Config{
+		State:        int(state),
+		Plug:         int(plug),
+		MaxCurrent:   int(maxCurrent),
+		CurrentLimit: int(currentLimit),
+		EnergyLimit:  int(energyLimit),
+	}, nil
+}
+
+func (m *modbusClient) Session() (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	energy, err := m.u32(regEnergySession)
+	if err != nil {
+		return nil, err
+	}
+	total, err := m.u32(regEnergyTotal)
+	if err != nil {
+		return nil, err
+	}
+	u1, err := m.u16(regVoltage1)
+	if err != nil {
+		return nil, err
+	}
+	u2, err := m.u16(regVoltage2)
+	if err != nil {
+		return nil, err
+	}
+	u3, err := m.u16(regVoltage3)
+	if err != nil {
+		return nil, err
+	}
+	i1, err := m.u16(regCurrent1)
+	if err != nil {
+		return nil, err
+	}
+	i2, err := m.u16(regCurrent2)
+	if err != nil {
+		return nil, err
+	}
+	i3, err := m.u16(regCurrent3)
+	if err != nil {
+		return nil, err
+	}
+	power, err := m.u32(regPower)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		Energy:   int(energy),
+		Total:    int(total),
+		Voltage1: int(u1),
+		Voltage2: int(u2),
+		Voltage3: int(u3),
+		Current1: int(i1),
+		Current2: int(i2),
+		Current3: int(i3),
+		Power:    int(power),
+	}, nil
+}
+
+// History is a best-effort mapping: unlike the UDP report 100-130 ring
+// buffer, the Modbus register map only exposes the live session, so at
+// most one entry is returned.
+func (m *modbusClient) History() ([]Log, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tag, err := m.ascii(regRFIDTag, 5)
+	if err != nil {
+		return nil, err
+	}
+	class, err := m.ascii(regRFIDClass, 5)
+	if err != nil {
+		return nil, err
+	}
+	if tag == "" {
+		return nil, nil
+	}
+
+	maxCurrent, err := m.u16(regMaxCurrent)
+	if err != nil {
+		return nil, err
+	}
+	energy, err := m.u32(regEnergySession)
+	if err != nil {
+		return nil, err
+	}
+	total, err := m.u32(regEnergyTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	// The register map has no session-id counter, so derive one: the
+	// total-energy reading at the moment this session started is
+	// constant for as long as the session is live and strictly
+	// increases from one session to the next, making it a stable,
+	// order-preserving stand-in for the UDP report's "Session ID".
+	startTotal := int(total) - int(energy)
+
+	return []Log{{
+		Session:    startTotal,
+		MaxCurrent: int(maxCurrent),
+		StartTotal: startTotal,
+		Energy:     int(energy),
+		RFIDTag:    tag,
+		RFIDClass:  class,
+	}}, nil
+}
+
+func (m *modbusClient) SetCurrent(mA int, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.writeU16(holdCurrent, uint16(mA)); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	return m.writeU16(holdCurrentTimer, uint16(ttl.Seconds()))
+}
+
+func (m *modbusClient) SetEnergyLimit(deciWh int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.writeU32(holdEnergyLimit, uint32(deciWh))
+}
+
+func (m *modbusClient) Enable(on bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v := uint16(0)
+	if on {
+		v = 1
+	}
+	return m.writeU16(holdEnable, v)
+}
+
+func (m *modbusClient) Unlock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.writeU16(holdUnlock, 1)
+}
+
+// Display is not supported: the KeContact P30 Modbus TCP register map
+// has no holding register for the wallbox's display text.
+func (m *modbusClient) Display(text string) error {
+	return fmt.Errorf("modbus: Display is not supported by this wallbox's register map")
+}
+
+// Close releases the underlying TCP connection opened by newModbus.
+func (m *modbusClient) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.handler.Close()
+}
+
+func (m *modbusClient) writeU16(addr uint16, v uint16) error {
+	_, err := m.client.WriteSingleRegister(addr, v)
+	return err
+}
+
+func (m *modbusClient) writeU32(addr uint16, v uint32) error {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	_, err := m.client.WriteMultipleRegisters(addr, 2, b)
+	return err
+}
+
+func (m *modbusClient) u16(addr uint16) (uint16, error) {
+	b, err := m.client.ReadInputRegisters(addr, 1)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func (m *modbusClient) u32(addr uint16) (uint32, error) {
+	b, err := m.client.ReadInputRegisters(addr, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (m *modbusClient) ascii(addr uint16, words uint16) (string, error) {
+	b, err := m.client.ReadInputRegisters(addr, words)
+	if err != nil {
+		return "", err
+	}
+
+	n := 0
+	for ; n < len(b) && b[n] != 0; n++ {
+	}
+	return string(b[:n]), nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttConfig holds the -mqtt-* flags, shared by every device's publisher.
+type mqttConfig struct {
+	Broker      string
+	TopicPrefix string
+	Username    string
+	Password    string
+	TLS         bool
+}
+
+func (c mqttConfig) enabled() bool { return c.Broker != "" }
+
+// mqttByDevice holds each device's publisher. main populates it while
+// devices are brought up one at a time, but by then earlier devices'
+// metrics goroutines are already scraping, so every access goes
+// through mqttMu - like metricsByDevice/historyByDevice.
+var (
+	mqttMu       sync.Mutex
+	mqttByDevice = map[string]*mqttPublisher{}
+)
+
+// setMQTTPublisher registers pub as device's MQTT publisher.
+func setMQTTPublisher(device string, pub *mqttPublisher) {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	mqttByDevice[device] = pub
+}
+
+// mqttPublisherFor returns device's MQTT publisher, or nil if MQTT
+// publishing isn't enabled for it.
+func mqttPublisherFor(device string) *mqttPublisher {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	return mqttByDevice[device]
+}
+
+// mqttPublisher publishes one device's polled snapshot to MQTT under
+// <prefix>/<device>/<field>, and mirrors it as Home Assistant
+// MQTT-Discovery sensors/controls. It never polls the wallbox itself;
+// publish is always fed the same Config/Session collect() already
+// fetched for Prometheus, so MQTT never doubles the UDP/Modbus traffic.
+type mqttPublisher struct {
+	paho   paho.Client
+	client Client // the wallbox, for control topics
+	prefix string
+	device string  // the configured device name: unique, unlike sys.Serial (Modbus never sets it)
+	sys    *System // fetched once in main; published alongside Config/Session
+
+	discovered bool
+}
+
+func newMQTTPublisher(cfg mqttConfig, device string, client Client, sys *System) (*mqttPublisher, error) {
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID("keba-exporter-" + device).
+		SetAutoReconnect(true)
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+	if cfg.TLS {
+		opts.SetTLSConfig(&tls.Config{})
+	}
+
+	pahoClient := paho.NewClient(opts)
+	if token := pahoClient.Connect(); token.Wait() && token.Error() != nil {
+		return nil, token.Error()
+	}
+
+	p := &mqttPublisher{
+		paho:   pahoClient,
+		client: client,
+		prefix: cfg.TopicPrefix,
+		device: device,
+		sys:    sys,
+	}
+	if err := p.subscribeControls(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// publish sends sys/cfg/sess as retained messages and, on first use,
+// the Home Assistant discovery config for every field.
+func (p *mqttPublisher) publish(cfg *Config, sess *Session) {
+	p.discover()
+
+	fields := map[string]string{
+		"power":             fmt.Sprintf("%g", float64(sess.Power)/1000),
+		"energy_total_wh":   fmt.Sprintf("%g", float64(sess.Total)/10),
+		"energy_session_wh": fmt.Sprintf("%g", float64(sess.Energy)/10),
+		"voltage_1":         strconv.Itoa(sess.Voltage1),
+		"voltage_2":         strconv.Itoa(sess.Voltage2),
+		"voltage_3":         strconv.Itoa(sess.Voltage3),
+		"current_1":         fmt.Sprintf("%g", float64(sess.Current1)/1000),
+		"current_2":         fmt.Sprintf("%g", float64(sess.Current2)/1000),
+		"current_3":         fmt.Sprintf("%g", float64(sess.Current3)/1000),
+		"state":             strconv.Itoa(cfg.State),
+		"plug_station":      onoff(cfg.Plug&PlugStation != 0),
+		"plug_locked":       onoff(cfg.Plug&PlugLocked != 0),
+		"plug_ev":           onoff(cfg.Plug&PlugEV != 0),
+		"current_limit":     fmt.Sprintf("%g", float64(cfg.CurrentLimit)/1000),
+		"enable":            onoff(cfg.State != StateNotReady),
+		"product":           p.sys.Product,
+		"serial":            p.sys.Serial,
+		"firmware":          p.sys.Firmware,
+		"backend":           strconv.Itoa(p.sys.Backend),
+		"dips":              p.sys.DIPs.String(),
+	}
+
+	for field, v := range fields {
+		p.paho.Publish(p.topic(field), 0, true, v)
+	}
+}
+
+func (p *mqttPublisher) topic(field string) string {
+	return fmt.Sprintf("%s/%s/%s", p.prefix, p.device, field)
+}
+
+func onoff(b bool) string {
+	if b {
+		return "ON"
+	}
+	return "OFF"
+}
+
+// subscribeControls wires the "set/*" command topics to the write API
+// (see control.go), so Home Assistant's switch/number entities can
+// drive charging without a separate integration.
+func (p *mqttPublisher) subscribeControls() error {
+	enable := func(_ paho.Client, msg paho.Message) {
+		if err := p.client.Enable(string(msg.Payload()) == "ON"); err != nil {
+			log.Println(err)
+		}
+	}
+	if token := p.paho.Subscribe(p.topic("set/enable"), 0, enable); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	current := func(_ paho.Client, msg paho.Message) {
+		mA, err := strconv.Atoi(string(msg.Payload()))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if err := p.client.SetCurrent(mA, 0); err != nil {
+			log.Println(err)
+		}
+	}
+	if token := p.paho.Subscribe(p.topic("set/current_limit"), 0, current); token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+
+	return nil
+}
+
+// haDevice groups every entity of one wallbox under a single Home
+// Assistant Device.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+type haSensor struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	UnitOfMeasurement string   `json:"unit_of_measurement,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+type haSwitch struct {
+	Name         string   `json:"name"`
+	UniqueID     string   `json:"unique_id"`
+	StateTopic   string   `json:"state_topic"`
+	CommandTopic string   `json:"command_topic"`
+	Device       haDevice `json:"device"`
+}
+
+type haNumber struct {
+	Name         string   `json:"name"`
+	UniqueID     string   `json:"unique_id"`
+	StateTopic   string   `json:"state_topic"`
+	CommandTopic string   `json:"command_topic"`
+	Min          int      `json:"min"`
+	Max          int      `json:"max"`
+	Step         int      `json:"step"`
+	Device       haDevice `json:"device"`
+}
+
+func (p *mqttPublisher) discover() {
+	if p.discovered {
+		return
+	}
+	p.discovered = true
+
+	dev := haDevice{
+		Identifiers:  []string{p.device},
+		Name:         "KEBA " + p.device,
+		Manufacturer: "KEBA",
+	}
+
+	sensors := []struct{ field, name, unit string }{
+		{"power", "Power", "kW"},
+		{"energy_total_wh", "Total Energy", "Wh"},
+		{"energy_session_wh", "Session Energy", "Wh"},
+		{"voltage_1", "Voltage L1", "V"},
+		{"voltage_2", "Voltage L2", "V"},
+		{"voltage_3", "Voltage L3", "V"},
+		{"current_1", "Current L1", "A"},
+		{"current_2", "Current L2", "A"},
+		{"current_3", "Current L3", "A"},
+		{"state", "Charging State", ""},
+		{"plug_station", "Plug: Station", ""},
+		{"plug_locked", "Plug: Locked", ""},
+		{"plug_ev", "Plug: EV", ""},
+		{"product", "Product", ""},
+		{"serial", "Serial", ""},
+		{"firmware", "Firmware", ""},
+		{"backend", "Backend", ""},
+		{"dips", "DIP Switches", ""},
+	}
+	for _, s := range sensors {
+		p.publishDiscovery("sensor", s.field, haSensor{
+			Name:              s.name,
+			UniqueID:          p.uniqueID(s.field),
+			StateTopic:        p.topic(s.field),
+			UnitOfMeasurement: s.unit,
+			Device:            dev,
+		})
+	}
+
+	p.publishDiscovery("switch", "enable", haSwitch{
+		Name:         "Charging Enabled",
+		UniqueID:     p.uniqueID("enable"),
+		StateTopic:   p.topic("enable"),
+		CommandTopic: p.topic("set/enable"),
+		Device:       dev,
+	})
+
+	p.publishDiscovery("number", "current_limit", haNumber{
+		Name:         "Current Limit",
+		UniqueID:     p.uniqueID("current_limit"),
+		StateTopic:   p.topic("current_limit"),
+		CommandTopic: p.topic("set/current_limit"),
+		Min:          6000,
+		Max:          32000,
+		Step:         1000,
+		Device:       dev,
+	})
+}
+
+func (p *mqttPublisher) uniqueID(field string) string {
+	return fmt.Sprintf("keba_%s_%s", p.device, field)
+}
+
+func (p *mqttPublisher) publishDiscovery(component, field string, cfg interface{}) {
+	payload, err := json.Marshal(cfg)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	topic := fmt.Sprintf("homeassistant/%s/%s/config", component, p.uniqueID(field))
+	p.paho.Publish(topic, 0, true, payload)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Defaults for retryClient, matching the KEBA UDP socket's usual
+// recovery time after a dropped packet.
+const (
+	defaultAttempts = 3
+	defaultBackoff  = 200 * time.Millisecond
+	defaultMaxWait  = 2 * time.Second
+)
+
+// retryClient wraps a Client with exponential backoff-with-jitter
+// retries around every call, to smooth over transient packet loss on
+// the wallbox's single-listener UDP socket. Per-command timeouts stay
+// owned by the wrapped Client (udp's fixed read deadline, modbus's
+// handler.Timeout) - retryClient only decides whether and how long to
+// wait before trying again.
+type retryClient struct {
+	Client
+
+	attempts int
+	backoff  time.Duration
+	maxWait  time.Duration
+
+	// onRetry, if set, is called once per retried attempt.
+	onRetry func()
+}
+
+var _ Client = &retryClient{}
+
+func newRetryClient(c Client, onRetry func()) *retryClient {
+	return &retryClient{
+		Client:   c,
+		attempts: defaultAttempts,
+		backoff:  defaultBackoff,
+		maxWait:  defaultMaxWait,
+		onRetry:  onRetry,
+	}
+}
+
+func (r *retryClient) System() (*System, error) {
+	var s *System
+	err := r.retry(func() (err error) {
+		s, err = r.Client.System()
+		return err
+	})
+	return s, err
+}
+
+func (r *retryClient) Config() (*Config, error) {
+	var c *Config
+	err := r.retry(func() (err error) {
+		c, err = r.Client.Config()
+		return err
+	})
+	return c, err
+}
+
+func (r *retryClient) Session() (*Session, error) {
+	var s *Session
+	err := r.retry(func() (err error) {
+		s, err = r.Client.Session()
+		return err
+	})
+	return s, err
+}
+
+func (r *retryClient) History() ([]Log, error) {
+	var h []Log
+	err := r.retry(func() (err error) {
+		h, err = r.Client.History()
+		return err
+	})
+	return h, err
+}
+
+func (r *retryClient) SetCurrent(mA int, ttl time.Duration) error {
+	return r.retry(func() error { return r.Client.SetCurrent(mA, ttl) })
+}
+
+func (r *retryClient) SetEnergyLimit(deciWh int) error {
+	return r.retry(func() error { return r.Client.SetEnergyLimit(deciWh) })
+}
+
+func (r *retryClient) Enable(on bool) error {
+	return r.retry(func() error { return r.Client.Enable(on) })
+}
+
+func (r *retryClient) Unlock() error {
+	return r.retry(func() error { return r.Client.Unlock() })
+}
+
+func (r *retryClient) Display(text string) error {
+	return r.retry(func() error { return r.Client.Display(text) })
+}
+
+func (r *retryClient) retry(fn func() error) error {
+	var err error
+	for attempt := 0; attempt < r.attempts; attempt++ {
+		if attempt > 0 {
+			if r.onRetry != nil {
+				r.onRetry()
+			}
+			time.Sleep(jitter(attempt-1, r.backoff, r.maxWait))
+		}
+
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// jitter returns a random duration in [0, min(maxWait, backoff*2^attempt)],
+// a full-jitter exponential backoff as popularized by the AWS
+// architecture blog.
+func jitter(attempt int, backoff, maxWait time.Duration) time.Duration {
+	d := backoff * time.Duration(int64(1)<<uint(attempt))
+	if d <= 0 || d > maxWait {
+		d = maxWait
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
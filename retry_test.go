@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJitterBounds(t *testing.T) {
+	backoff := 200 * time.Millisecond
+	maxWait := 2 * time.Second
+
+	for attempt := 0; attempt < 10; attempt++ {
+		for i := 0; i < 50; i++ {
+			d := jitter(attempt, backoff, maxWait)
+			if d < 0 || d > maxWait {
+				t.Fatalf("jitter(%d, ...) = %v, want in [0, %v]", attempt, d, maxWait)
+			}
+		}
+	}
+}
+
+func TestJitterCapsAtMaxWait(t *testing.T) {
+	// attempt large enough that backoff*2^attempt overflows/exceeds maxWait
+	backoff := 200 * time.Millisecond
+	maxWait := 2 * time.Second
+
+	d := jitter(20, backoff, maxWait)
+	if d > maxWait {
+		t.Fatalf("jitter did not cap at maxWait: got %v, want <= %v", d, maxWait)
+	}
+}
+
+func TestRetrySucceedsWithoutRetrying(t *testing.T) {
+	calls := 0
+	r := newRetryClient(nil, nil)
+	r.backoff = time.Millisecond
+	r.maxWait = time.Millisecond
+
+	err := r.retry(func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("retry() = %v, want nil", err)
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestRetryGivesUpAfterAttempts(t *testing.T) {
+	wantErr := errors.New("boom")
+	calls := 0
+
+	retries := 0
+	r := newRetryClient(nil, func() { retries++ })
+	r.attempts = 3
+	r.backoff = time.Millisecond
+	r.maxWait = time.Millisecond
+
+	err := r.retry(func() error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("retry() = %v, want %v", err, wantErr)
+	}
+	if calls != r.attempts {
+		t.Fatalf("fn called %d times, want %d", calls, r.attempts)
+	}
+	if retries != r.attempts-1 {
+		t.Fatalf("onRetry called %d times, want %d", retries, r.attempts-1)
+	}
+}
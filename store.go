@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// store is the persistent HistoryStore, if -history-db was given. Left
+// nil otherwise, in which case /history falls back to the wallbox's own
+// in-RAM ring buffer.
+var store HistoryStore
+
+// HistoryStore persists charging sessions so long-term energy
+// accounting survives both exporter restarts and the wallbox's
+// 30-entry ring buffer rollover.
+type HistoryStore interface {
+	// Ingest stores log for device, deduplicating by Session id.
+	// inserted reports whether this was a previously unseen session.
+	Ingest(device string, log Log) (inserted bool, err error)
+
+	// Query returns sessions matching q, ordered by Start ascending.
+	Query(q Query) ([]StoredLog, error)
+}
+
+// Query filters a HistoryStore.Query call. A zero From/To or empty
+// Device/RFID is unfiltered.
+type Query struct {
+	Device string
+	From   time.Time
+	To     time.Time
+	RFID   string
+}
+
+// StoredLog is a Log enriched with the device it was polled from.
+type StoredLog struct {
+	Device string `json:"device"`
+	Log
+}
+
+type sqliteStore struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	device      TEXT NOT NULL,
+	session     INTEGER NOT NULL,
+	max_current INTEGER,
+	start_total INTEGER,
+	energy      INTEGER,
+	start       INTEGER,
+	"end"       INTEGER,
+	end_reason  INTEGER,
+	rfid_tag    TEXT,
+	rfid_class  TEXT,
+	PRIMARY KEY (device, session)
+);
+`
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Ingest(device string, l Log) (bool, error) {
+	res, err := s.db.Exec(`
+		INSERT OR IGNORE INTO sessions
+			(device, session, max_current, start_total, energy, start, "end", end_reason, rfid_tag, rfid_class)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, device, l.Session, l.MaxCurrent, l.StartTotal, l.Energy, l.Start, l.End, l.EndReason, l.RFIDTag, l.RFIDClass)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqliteStore) Query(q Query) ([]StoredLog, error) {
+	sqlq := `
+		SELECT device, session, max_current, start_total, energy, start, "end", end_reason, rfid_tag, rfid_class
+		FROM sessions WHERE 1=1
+	`
+	var args []interface{}
+
+	if q.Device != "" {
+		sqlq += " AND device = ?"
+		args = append(args, q.Device)
+	}
+	if !q.From.IsZero() {
+		sqlq += " AND start >= ?"
+		args = append(args, q.From.Unix())
+	}
+	if !q.To.IsZero() {
+		sqlq += " AND start <= ?"
+		args = append(args, q.To.Unix())
+	}
+	if q.RFID != "" {
+		sqlq += " AND rfid_tag = ?"
+		args = append(args, q.RFID)
+	}
+	sqlq += " ORDER BY start ASC"
+
+	rows, err := s.db.Query(sqlq, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []StoredLog
+	for rows.Next() {
+		var l StoredLog
+		if err := rows.Scan(&l.Device, &l.Session, &l.MaxCurrent, &l.StartTotal, &l.Energy, &l.Start, &l.End, &l.EndReason, &l.RFIDTag, &l.RFIDClass); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
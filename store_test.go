@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *sqliteStore {
+	t.Helper()
+	s, err := newSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("newSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { s.db.Close() })
+	return s
+}
+
+func TestIngestDedupsBySession(t *testing.T) {
+	s := newTestStore(t)
+
+	l := Log{Session: 1, Energy: 100, RFIDTag: "abc"}
+	inserted, err := s.Ingest("wallbox1", l)
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	if !inserted {
+		t.Fatal("Ingest() inserted = false, want true on first insert")
+	}
+
+	inserted, err = s.Ingest("wallbox1", l)
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	if inserted {
+		t.Fatal("Ingest() inserted = true, want false on duplicate session")
+	}
+
+	// same session id on a different device is a distinct row
+	inserted, err = s.Ingest("wallbox2", l)
+	if err != nil {
+		t.Fatalf("Ingest: %v", err)
+	}
+	if !inserted {
+		t.Fatal("Ingest() inserted = false, want true for a different device")
+	}
+}
+
+func TestQueryFilters(t *testing.T) {
+	s := newTestStore(t)
+
+	sessions := []struct {
+		device string
+		l      Log
+	}{
+		{"wallbox1", Log{Session: 1, Start: 100, RFIDTag: "aaa"}},
+		{"wallbox1", Log{Session: 2, Start: 200, RFIDTag: "bbb"}},
+		{"wallbox2", Log{Session: 1, Start: 150, RFIDTag: "aaa"}},
+	}
+	for _, s2 := range sessions {
+		if _, err := s.Ingest(s2.device, s2.l); err != nil {
+			t.Fatalf("Ingest: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		q    Query
+		want []int // expected Start values, in order
+	}{
+		{"no filter", Query{}, []int{100, 150, 200}},
+		{"by device", Query{Device: "wallbox1"}, []int{100, 200}},
+		{"by rfid", Query{RFID: "aaa"}, []int{100, 150}},
+		{"by from", Query{From: time.Unix(150, 0)}, []int{150, 200}},
+		{"by to", Query{To: time.Unix(150, 0)}, []int{100, 150}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Query(tt.q)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Query(%+v) returned %d rows, want %d", tt.q, len(got), len(tt.want))
+			}
+			for i, l := range got {
+				if l.Start != tt.want[i] {
+					t.Errorf("Query(%+v)[%d].Start = %d, want %d", tt.q, i, l.Start, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWriteHistoryCSV(t *testing.T) {
+	logs := []StoredLog{
+		{Device: "wallbox1", Log: Log{Session: 1, MaxCurrent: 16000, Energy: 500, RFIDTag: "aaa", RFIDClass: "classic"}},
+	}
+
+	w := httptest.NewRecorder()
+	writeHistoryCSV(w, logs)
+
+	want := "device,session,max_current,start_total,energy,start,end,end_reason,rfid_tag,rfid_class\nwallbox1,1,16000,0,500,0,0,0,aaa,classic\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("writeHistoryCSV() = %q, want %q", got, want)
+	}
+}